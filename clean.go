@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// deleteBatchSize is the maximum number of keys a single DeleteObjects call
+// accepts on S3 (and the batch size we use for other backends too, for
+// consistency).
+const deleteBatchSize = 1000
+
+// CleanOptions controls how cleanUnused decides what to delete.
+type CleanOptions struct {
+	DryRun      bool
+	Concurrency int
+	MinAge      time.Duration
+	MaxDeletes  int
+}
+
+// Report summarizes the outcome of a cleanUnused run.
+type Report struct {
+	Deleted    int
+	Skipped    int
+	Capped     int
+	BytesFreed int64
+	Errors     map[string]error
+}
+
+// cleanUnused deletes every blob in rd that isn't referenced by a repository
+// link, subject to opts. Blobs are handed to opts.Concurrency workers over a
+// channel, and each worker batches its deletes into DeleteObjects calls of
+// up to deleteBatchSize keys.
+func cleanUnused(ctx context.Context, storage RegistryStorage, rd *repoData, opts CleanOptions) (Report, error) {
+	report := Report{Errors: map[string]error{}}
+	var reportMu sync.Mutex
+
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	cutoff := time.Now().Add(-opts.MinAge)
+	candidates := make(chan *blobEntry)
+	var deleted int64
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cleanWorker(ctx, storage, opts, candidates, &deleted, &report, &reportMu)
+		}()
+	}
+
+	for _, entry := range rd.blobs {
+		if entry.used {
+			continue
+		}
+		if entry.lastModified.After(cutoff) {
+			reportMu.Lock()
+			report.Skipped++
+			reportMu.Unlock()
+			continue
+		}
+		candidates <- entry
+	}
+	close(candidates)
+	wg.Wait()
+
+	return report, nil
+}
+
+// cleanWorker drains candidates, batching up to deleteBatchSize entries per
+// DeleteObjects call. Once opts.MaxDeletes has been reached it keeps
+// draining candidates, counting each further entry as Capped instead of
+// deleting it.
+func cleanWorker(ctx context.Context, storage RegistryStorage, opts CleanOptions, candidates <-chan *blobEntry, deleted *int64, report *Report, reportMu *sync.Mutex) {
+	batch := make([]*blobEntry, 0, deleteBatchSize)
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		freed, errs := deleteBatch(ctx, storage, batch, opts.DryRun)
+
+		reportMu.Lock()
+		report.Deleted += len(batch) - len(errs)
+		report.BytesFreed += freed
+		for key, err := range errs {
+			report.Errors[key] = err
+		}
+		reportMu.Unlock()
+
+		batch = batch[:0]
+	}
+
+	for entry := range candidates {
+		if opts.MaxDeletes > 0 {
+			if n := atomic.AddInt64(deleted, 1); int(n) > opts.MaxDeletes {
+				reportMu.Lock()
+				report.Capped++
+				reportMu.Unlock()
+				continue
+			}
+		}
+
+		batch = append(batch, entry)
+		if len(batch) >= deleteBatchSize {
+			flush()
+		}
+	}
+	flush()
+}
+
+// deleteBatch issues a single DeleteObjects call for batch (or skips it
+// entirely in dry-run mode) and returns bytes freed plus any per-key errors.
+func deleteBatch(ctx context.Context, storage RegistryStorage, batch []*blobEntry, dryRun bool) (int64, map[string]error) {
+	var freed int64
+	for _, entry := range batch {
+		freed += entry.size
+	}
+
+	if dryRun {
+		for _, entry := range batch {
+			sha256, _ := sha256FromBlobKey(entry.key)
+			slog.Info("would delete blob", "key", entry.key, "sha256", sha256, "action", "delete-dry-run")
+		}
+		return freed, nil
+	}
+
+	keys := make([]string, len(batch))
+	for i, entry := range batch {
+		keys[i] = entry.key
+	}
+
+	start := time.Now()
+	errs, err := storage.DeleteObjects(ctx, keys)
+	duration := time.Since(start)
+
+	if err != nil {
+		allErrs := map[string]error{}
+		for _, entry := range batch {
+			allErrs[entry.key] = err
+			slog.Error("delete failed", "key", entry.key, "action", "delete", "duration_ms", duration.Milliseconds(), "error", err)
+		}
+		return 0, allErrs
+	}
+
+	freed = 0
+	for _, entry := range batch {
+		if failErr, failed := errs[entry.key]; failed {
+			slog.Error("delete failed", "key", entry.key, "action", "delete", "duration_ms", duration.Milliseconds(), "error", failErr)
+			continue
+		}
+		freed += entry.size
+		blobsDeletedTotal.Inc()
+		bytesFreedTotal.Add(float64(entry.size))
+		sha256, _ := sha256FromBlobKey(entry.key)
+		slog.Info("deleted blob", "key", entry.key, "sha256", sha256, "action", "delete", "duration_ms", duration.Milliseconds())
+	}
+
+	return freed, errs
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeStorage is a minimal in-memory RegistryStorage for exercising
+// cleanUnused without touching S3 or the filesystem. It only implements the
+// methods cleanUnused actually calls; ListPrefix/ListDir/GetObject/Stat are
+// unused by the code under test here.
+type fakeStorage struct {
+	mu                 sync.Mutex
+	deleteObjectsCalls int32
+	deleteObjectsKeys  [][]string
+	failKeys           map[string]error
+}
+
+func (f *fakeStorage) ListPrefix(ctx context.Context, prefix string, fn func(ObjectInfo) bool) error {
+	return nil
+}
+
+func (f *fakeStorage) ListDir(ctx context.Context, prefix string) ([]string, error) {
+	return nil, nil
+}
+
+func (f *fakeStorage) GetObject(ctx context.Context, key string) ([]byte, error) {
+	return nil, nil
+}
+
+func (f *fakeStorage) DeleteObjects(ctx context.Context, keys []string) (map[string]error, error) {
+	atomic.AddInt32(&f.deleteObjectsCalls, 1)
+
+	f.mu.Lock()
+	f.deleteObjectsKeys = append(f.deleteObjectsKeys, append([]string{}, keys...))
+	f.mu.Unlock()
+
+	var errs map[string]error
+	for _, key := range keys {
+		if err, fail := f.failKeys[key]; fail {
+			if errs == nil {
+				errs = map[string]error{}
+			}
+			errs[key] = err
+		}
+	}
+	return errs, nil
+}
+
+func (f *fakeStorage) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	return ObjectInfo{}, nil
+}
+
+func TestCleanUnusedDryRunDoesNotCallDeleteObjects(t *testing.T) {
+	storage := &fakeStorage{}
+	rd := &repoData{blobs: map[string]*blobEntry{
+		"a": {key: "blobs/a/data", size: 10, lastModified: time.Now().Add(-time.Hour)},
+		"b": {key: "blobs/b/data", size: 20, lastModified: time.Now().Add(-time.Hour)},
+	}}
+
+	report, err := cleanUnused(context.Background(), storage, rd, CleanOptions{DryRun: true, Concurrency: 2})
+	if err != nil {
+		t.Fatalf("cleanUnused: %v", err)
+	}
+
+	if n := atomic.LoadInt32(&storage.deleteObjectsCalls); n != 0 {
+		t.Errorf("DeleteObjects called %d times in dry-run, want 0", n)
+	}
+	if report.Deleted != 2 {
+		t.Errorf("Deleted = %d, want 2", report.Deleted)
+	}
+	if report.BytesFreed != 30 {
+		t.Errorf("BytesFreed = %d, want 30", report.BytesFreed)
+	}
+}
+
+func TestCleanUnusedSkipsBlobsNewerThanMinAge(t *testing.T) {
+	storage := &fakeStorage{}
+	rd := &repoData{blobs: map[string]*blobEntry{
+		"old": {key: "blobs/old/data", size: 10, lastModified: time.Now().Add(-time.Hour)},
+		"new": {key: "blobs/new/data", size: 20, lastModified: time.Now()},
+	}}
+
+	report, err := cleanUnused(context.Background(), storage, rd, CleanOptions{Concurrency: 1, MinAge: 30 * time.Minute})
+	if err != nil {
+		t.Fatalf("cleanUnused: %v", err)
+	}
+
+	if report.Skipped != 1 {
+		t.Errorf("Skipped = %d, want 1", report.Skipped)
+	}
+	if report.Deleted != 1 {
+		t.Errorf("Deleted = %d, want 1", report.Deleted)
+	}
+
+	var deletedKeys []string
+	for _, keys := range storage.deleteObjectsKeys {
+		deletedKeys = append(deletedKeys, keys...)
+	}
+	if len(deletedKeys) != 1 || deletedKeys[0] != "blobs/old/data" {
+		t.Errorf("deleted keys = %v, want [blobs/old/data]", deletedKeys)
+	}
+}
+
+func TestCleanUnusedMaxDeletesCapsUnderConcurrency(t *testing.T) {
+	storage := &fakeStorage{}
+	rd := &repoData{blobs: map[string]*blobEntry{}}
+
+	const total = 200
+	for i := 0; i < total; i++ {
+		key := fmt.Sprintf("blob%03d", i)
+		rd.blobs[key] = &blobEntry{key: "blobs/" + key + "/data", size: 1, lastModified: time.Now().Add(-time.Hour)}
+	}
+
+	report, err := cleanUnused(context.Background(), storage, rd, CleanOptions{Concurrency: 16, MaxDeletes: 10})
+	if err != nil {
+		t.Fatalf("cleanUnused: %v", err)
+	}
+
+	if report.Deleted != 10 {
+		t.Errorf("Deleted = %d, want 10", report.Deleted)
+	}
+	if report.Capped != total-10 {
+		t.Errorf("Capped = %d, want %d", report.Capped, total-10)
+	}
+
+	var deletedKeys int
+	for _, keys := range storage.deleteObjectsKeys {
+		deletedKeys += len(keys)
+	}
+	if deletedKeys != 10 {
+		t.Errorf("DeleteObjects issued for %d keys, want 10", deletedKeys)
+	}
+}
+
+func TestCleanUnusedPropagatesPartialDeleteErrors(t *testing.T) {
+	storage := &fakeStorage{failKeys: map[string]error{"blobs/bad/data": errors.New("access denied")}}
+	rd := &repoData{blobs: map[string]*blobEntry{
+		"good": {key: "blobs/good/data", size: 10, lastModified: time.Now().Add(-time.Hour)},
+		"bad":  {key: "blobs/bad/data", size: 20, lastModified: time.Now().Add(-time.Hour)},
+	}}
+
+	report, err := cleanUnused(context.Background(), storage, rd, CleanOptions{Concurrency: 1})
+	if err != nil {
+		t.Fatalf("cleanUnused: %v", err)
+	}
+
+	if report.Deleted != 1 {
+		t.Errorf("Deleted = %d, want 1", report.Deleted)
+	}
+	if report.BytesFreed != 10 {
+		t.Errorf("BytesFreed = %d, want 10", report.BytesFreed)
+	}
+	if len(report.Errors) != 1 {
+		t.Fatalf("Errors = %v, want exactly one entry", report.Errors)
+	}
+	if _, ok := report.Errors["blobs/bad/data"]; !ok {
+		t.Errorf("expected Errors to contain blobs/bad/data, got %v", report.Errors)
+	}
+}
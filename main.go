@@ -1,38 +1,26 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"strconv"
 	"strings"
-
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/endpoints"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
-	"github.com/caarlos0/spin"
+	"time"
 )
 
-type s3client struct {
-	sess       *session.Session
-	svc        *s3.S3
-	downloader *s3manager.Downloader
+type blobEntry struct {
+	key          string
+	size         int64
+	lastModified time.Time
+	used         bool
 }
 
 type repoData struct {
-	blobs map[string]bool
-}
-
-func getObjectContent(c s3client, bucket, key string) (string, error) {
-	buf := &aws.WriteAtBuffer{}
-	n, err := c.downloader.Download(buf, &s3.GetObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-	})
-
-	return string(buf.Bytes()[:n]), err
+	blobs map[string]*blobEntry
 }
 
 func sha256FromBlobKey(key string) (string, error) {
@@ -60,96 +48,150 @@ func isRepoLink(key string) bool {
 	return strings.Contains(key, "repositories") && strings.HasSuffix(key, "/link")
 }
 
-func readRepo(c s3client, bucket string) (*repoData, error) {
-	rd := &repoData{
-		blobs: map[string]bool{},
+// readRepo lists every blob and repository link in storage and returns the
+// resulting blob reference graph. Listing is sharded across listConcurrency
+// workers: blobs by their 256 two-hex-character sha256 subprefixes, and
+// repository links per repository.
+func readRepo(ctx context.Context, storage RegistryStorage, listConcurrency int) (*repoData, error) {
+	rd := &repoData{}
+
+	blobProgress := newProgressReporter("Listing blobs", 256)
+	blobs, err := listBlobsSharded(ctx, storage, listConcurrency, blobProgress)
+	if err != nil {
+		return nil, err
 	}
+	blobProgress.Finish()
+	rd.blobs = blobs
 
-	// List blobs
-	err := c.svc.ListObjectsPages(&s3.ListObjectsInput{
-		Bucket: aws.String(bucket),
-		Prefix: aws.String("docker/registry/v2/blobs"),
-	}, func(p *s3.ListObjectsOutput, last bool) (shouldContinue bool) {
-		for _, obj := range p.Contents {
+	linkProgress := newProgressReporter("Listing repository links", 0)
+	manifestRoots, err := listRepoLinksSharded(ctx, storage, rd, listConcurrency, linkProgress)
+	if err != nil {
+		return rd, err
+	}
+	linkProgress.Finish()
+
+	// Manifests reference their config and layer blobs (and, for manifest
+	// lists / OCI indexes, child manifests) by digest inside their JSON
+	// body, not via a dedicated link file, so those references have to be
+	// followed explicitly.
+	if err := walkManifests(ctx, storage, rd, manifestRoots); err != nil {
+		return rd, err
+	}
 
-			key := *obj.Key
-			if !isBlob(key) {
-				continue
-			}
+	return rd, nil
+}
 
-			sha256, err := sha256FromBlobKey(key)
-			if err != nil {
-				continue
-			}
+func newStorageFromFlags(backend, bucket, s3Region, s3Endpoint, storageRoot string, s3PathStyle bool) (RegistryStorage, error) {
+	switch backend {
+	case "s3":
+		return newS3Storage(S3Options{
+			Bucket:    bucket,
+			Region:    s3Region,
+			Endpoint:  s3Endpoint,
+			PathStyle: s3PathStyle,
+		})
+	case "filesystem":
+		return newFsStorage(storageRoot)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q (want s3 or filesystem)", backend)
+	}
+}
 
-			rd.blobs[sha256] = false
-		}
-		return true
-	})
-
-	// List repository links
-	err = c.svc.ListObjectsPages(&s3.ListObjectsInput{
-		Bucket: aws.String(bucket),
-		Prefix: aws.String("docker/registry/v2/repositories"),
-	}, func(p *s3.ListObjectsOutput, last bool) (shouldContinue bool) {
-		for _, obj := range p.Contents {
-
-			key := *obj.Key
-			if !isRepoLink(key) {
-				continue
-			}
-
-			ld, err := getObjectContent(c, bucket, key)
-			if err != nil {
-				continue
-			}
-			sha256 := strings.TrimPrefix(ld, "sha256:")
-
-			if _, ok := rd.blobs[sha256]; ok {
-				rd.blobs[sha256] = true
-			}
-		}
-		return true
-	})
+func envOr(name, fallback string) string {
+	if v, ok := os.LookupEnv(name); ok {
+		return v
+	}
+	return fallback
+}
 
-	return rd, err
+func envOrBool(name string, fallback bool) bool {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return fallback
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fallback
+	}
+	return b
 }
 
 func main() {
-	bucket := os.Getenv("REGISTRY_BUCKET")
-
-	// create and start a spinner
-	s := spin.New("%s Reading docker repository metadata ...")
-	s.Start()
-	defer s.Stop()
+	backend := flag.String("storage", envOr("REGISTRY_STORAGE", "s3"), "storage backend to use: s3 or filesystem")
+	bucket := flag.String("s3-bucket", os.Getenv("REGISTRY_BUCKET"), "S3 bucket the registry is stored in")
+	s3Region := flag.String("s3-region", os.Getenv("REGISTRY_S3_REGION"), "S3 region (AWS S3 default: us-east-1)")
+	s3Endpoint := flag.String("s3-endpoint", os.Getenv("REGISTRY_S3_ENDPOINT"), "custom S3 endpoint, for S3-compatible services like MinIO, Ceph RGW, Scaleway or Wasabi")
+	s3PathStyle := flag.Bool("s3-path-style", envOrBool("REGISTRY_S3_PATH_STYLE", false), "use path-style S3 addressing instead of virtual-hosted-style, as required by most S3-compatible services")
+	storageRoot := flag.String("storage-root", os.Getenv("REGISTRY_STORAGE_ROOT"), "root directory of the registry's filesystem storage")
+
+	dryRun := flag.Bool("dry-run", true, "report what would be deleted without deleting anything")
+	concurrency := flag.Int("concurrency", 4, "number of parallel delete workers")
+	listConcurrency := flag.Int("list-concurrency", 16, "number of parallel workers used to list blobs and repository links")
+	minAge := flag.Duration("min-age", time.Hour, "skip blobs modified more recently than this, to avoid racing in-progress pushes")
+	maxDeletes := flag.Int("max-deletes", 0, "maximum number of blobs to delete in this run (0 means unlimited)")
+	metricsAddr := flag.String("metrics-addr", os.Getenv("REGISTRY_METRICS_ADDR"), "address to serve Prometheus metrics on (e.g. :9090); disabled if empty")
+	flag.Parse()
+
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+
+	if *metricsAddr != "" {
+		serveMetrics(*metricsAddr)
+	}
 
-	// create s3 client, initiate session, s3 service and download manager
-	c := s3client{}
-	c.sess = session.Must(session.NewSession())
+	ctx := context.Background()
 
-	c.svc = s3.New(c.sess, &aws.Config{
-		Region: aws.String(endpoints.UsEast1RegionID),
-	})
+	storage, err := newStorageFromFlags(*backend, *bucket, *s3Region, *s3Endpoint, *storageRoot, *s3PathStyle)
+	if err != nil {
+		slog.Error("error configuring storage backend", "error", err)
+		os.Exit(1)
+	}
 
-	c.downloader = s3manager.NewDownloaderWithClient(c.svc)
+	slog.Info("reading repository metadata", "bucket", *bucket)
 
 	// read repository metadata
-	repoData, err := readRepo(c, bucket)
+	repoData, err := readRepo(ctx, storage, *listConcurrency)
 	if err != nil {
-		log.Fatalf("error reading repo: %v\n", err)
+		slog.Error("error reading repo", "bucket", *bucket, "error", err)
 		os.Exit(1)
 	}
 
 	// count blobs and usedBlobs
 	blobCount := 0
 	usedBlobCount := 0
-	for k, v := range repoData.blobs {
-		fmt.Println(k, v)
+	for _, v := range repoData.blobs {
 		blobCount++
-		if v {
+		if v.used {
 			usedBlobCount++
 		}
 	}
-	fmt.Println("Total blobs found:", blobCount)
-	fmt.Println("Blobs used by manifests:", usedBlobCount)
+	slog.Info("finished reading repository metadata", "bucket", *bucket, "blobs_total", blobCount, "blobs_used", usedBlobCount)
+
+	cleanOpts := CleanOptions{
+		DryRun:      *dryRun,
+		Concurrency: *concurrency,
+		MinAge:      *minAge,
+		MaxDeletes:  *maxDeletes,
+	}
+
+	report, err := cleanUnused(ctx, storage, repoData, cleanOpts)
+	if err != nil {
+		slog.Error("error cleaning unused blobs", "bucket", *bucket, "error", err)
+		os.Exit(1)
+	}
+
+	slog.Info("finished cleaning unused blobs", "bucket", *bucket, "action", "clean",
+		"blobs_deleted", report.Deleted, "blobs_skipped", report.Skipped, "blobs_capped", report.Capped, "bytes_freed", report.BytesFreed, "errors", len(report.Errors))
+
+	linkReport, err := cleanRepoLinks(ctx, storage, repoData, cleanOpts.DryRun)
+	if err != nil {
+		slog.Error("error cleaning repository links", "bucket", *bucket, "error", err)
+		os.Exit(1)
+	}
+
+	slog.Info("finished cleaning repository links", "bucket", *bucket, "action", "clean-repo-links",
+		"orphaned_links_removed", linkReport.Deleted,
+		"orphaned_tag_links_found", len(linkReport.OrphanedTagLinks),
+		"orphaned_layer_links_found", len(linkReport.OrphanedLayerLinks),
+		"empty_tag_dirs_found", len(linkReport.EmptyTagDirs),
+		"errors", len(linkReport.Errors))
 }
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+)
+
+// maxManifestDepth bounds recursion through manifest lists / OCI indexes, as
+// a guard against malformed or maliciously nested manifests.
+const maxManifestDepth = 10
+
+// manifestEnvelope covers the fields we need across every manifest shape a
+// registry can store: docker v2 manifests and manifest lists, OCI manifests
+// and indexes, and legacy schema1 manifests.
+type manifestEnvelope struct {
+	MediaType string `json:"mediaType"`
+	Config    *struct {
+		Digest string `json:"digest"`
+	} `json:"config,omitempty"`
+	Layers []struct {
+		Digest string `json:"digest"`
+	} `json:"layers,omitempty"`
+	Manifests []struct {
+		Digest string `json:"digest"`
+	} `json:"manifests,omitempty"`
+	FSLayers []struct {
+		BlobSum string `json:"blobSum"`
+	} `json:"fsLayers,omitempty"`
+}
+
+func isTagCurrentLink(key string) bool {
+	return strings.Contains(key, "/_manifests/tags/") && strings.HasSuffix(key, "/current/link")
+}
+
+func isRevisionLink(key string) bool {
+	return strings.Contains(key, "/_manifests/revisions/sha256/") && strings.HasSuffix(key, "/link")
+}
+
+func blobDataKey(sha256 string) string {
+	return "docker/registry/v2/blobs/sha256/" + sha256[:2] + "/" + sha256 + "/data"
+}
+
+// sha256HexLen is the length of a hex-encoded sha256 digest.
+const sha256HexLen = 64
+
+// isValidDigest reports whether digest (with any "sha256:" prefix already
+// trimmed) looks like a well-formed sha256 hex digest. Link files and
+// manifest JSON are untrusted input: a truncated link from an interrupted
+// push, or a manifest-list entry with an empty or malformed digest, must be
+// treated as unreadable rather than passed on to blobDataKey, which slices
+// into the first two characters.
+func isValidDigest(digest string) bool {
+	if len(digest) != sha256HexLen {
+		return false
+	}
+	for _, c := range digest {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// markUsed flags sha256 as used if it was seen during the blob listing. A
+// digest referenced by a manifest but missing from rd.blobs means the blob
+// is already gone, so there's nothing to protect.
+func markUsed(rd *repoData, sha256 string) {
+	if entry, ok := rd.blobs[sha256]; ok && !entry.used {
+		entry.used = true
+		blobsReferencedTotal.Inc()
+	}
+}
+
+// walkManifests recursively marks every blob transitively referenced by the
+// manifests in roots as used: each manifest itself, its config blob, its
+// layer blobs, and, for manifest lists / OCI indexes, every child manifest's
+// own references. visited is shared across roots so a manifest reached more
+// than once (e.g. both a tag's current link and a revision link pointing at
+// it, or a child listed by two indexes) is only fetched once. Config and
+// layer blobs are only marked, never fetched.
+func walkManifests(ctx context.Context, storage RegistryStorage, rd *repoData, roots []string) error {
+	visited := map[string]bool{}
+	for _, root := range roots {
+		if err := walkManifest(ctx, storage, rd, root, visited, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkManifest(ctx context.Context, storage RegistryStorage, rd *repoData, digest string, visited map[string]bool, depth int) error {
+	if depth > maxManifestDepth || visited[digest] {
+		return nil
+	}
+	visited[digest] = true
+
+	if !isValidDigest(digest) {
+		// Empty or malformed digest, e.g. from a truncated link file or a
+		// manifest-list entry with an empty "digest"; nothing to walk or
+		// protect.
+		return nil
+	}
+
+	markUsed(rd, digest)
+
+	data, err := storage.GetObject(ctx, blobDataKey(digest))
+	if err != nil {
+		// Blob is missing or unreadable; nothing further to walk from here.
+		return nil
+	}
+
+	var env manifestEnvelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		// Not a manifest we understand (or corrupt); treat as a leaf.
+		return nil
+	}
+
+	if strings.Contains(env.MediaType, "manifest.list") || strings.Contains(env.MediaType, "image.index") || len(env.Manifests) > 0 {
+		for _, m := range env.Manifests {
+			if err := walkManifest(ctx, storage, rd, trimDigestPrefix(m.Digest), visited, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if env.Config != nil {
+		markUsed(rd, trimDigestPrefix(env.Config.Digest))
+	}
+	for _, l := range env.Layers {
+		markUsed(rd, trimDigestPrefix(l.Digest))
+	}
+	for _, l := range env.FSLayers {
+		markUsed(rd, trimDigestPrefix(l.BlobSum))
+	}
+
+	return nil
+}
+
+func trimDigestPrefix(digest string) string {
+	return strings.TrimPrefix(digest, "sha256:")
+}
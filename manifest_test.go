@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testDigest derives a well-formed sha256 hex digest from label, so table
+// cases can use readable names while still exercising the real digest
+// validation in walkManifest.
+func testDigest(label string) string {
+	sum := sha256.Sum256([]byte(label))
+	return hex.EncodeToString(sum[:])
+}
+
+func writeBlobFixture(t *testing.T, root, digest string, data []byte) {
+	t.Helper()
+
+	path := filepath.Join(root, blobDataKey(digest))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWalkManifestMediaTypes(t *testing.T) {
+	childDigest := testDigest("child1")
+	childCfgDigest := testDigest("childcfg")
+	childLayerDigest := testDigest("childlayer")
+
+	childManifest, err := json.Marshal(map[string]interface{}{
+		"mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+		"config":    map[string]string{"digest": "sha256:" + childCfgDigest},
+		"layers":    []map[string]string{{"digest": "sha256:" + childLayerDigest}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg1 := testDigest("cfg1")
+	layer1 := testDigest("layer1")
+	layer2 := testDigest("layer2")
+	ociCfg := testDigest("ocicfg")
+	ociLayer := testDigest("ocilayer")
+	fsLayer1 := testDigest("fslayer1")
+	fsLayer2 := testDigest("fslayer2")
+
+	tests := []struct {
+		name     string
+		manifest map[string]interface{}
+		extra    map[string][]byte
+		wantUsed []string
+	}{
+		{
+			name: "docker manifest v2 marks config and layers",
+			manifest: map[string]interface{}{
+				"mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+				"config":    map[string]string{"digest": "sha256:" + cfg1},
+				"layers": []map[string]string{
+					{"digest": "sha256:" + layer1},
+					{"digest": "sha256:" + layer2},
+				},
+			},
+			wantUsed: []string{cfg1, layer1, layer2},
+		},
+		{
+			name: "oci manifest marks config and layers",
+			manifest: map[string]interface{}{
+				"mediaType": "application/vnd.oci.image.manifest.v1+json",
+				"config":    map[string]string{"digest": "sha256:" + ociCfg},
+				"layers":    []map[string]string{{"digest": "sha256:" + ociLayer}},
+			},
+			wantUsed: []string{ociCfg, ociLayer},
+		},
+		{
+			name: "manifest list marks child manifest transitively",
+			manifest: map[string]interface{}{
+				"mediaType": "application/vnd.docker.distribution.manifest.list.v2+json",
+				"manifests": []map[string]string{{"digest": "sha256:" + childDigest}},
+			},
+			extra:    map[string][]byte{childDigest: childManifest},
+			wantUsed: []string{childDigest, childCfgDigest, childLayerDigest},
+		},
+		{
+			name: "oci index marks child manifest transitively",
+			manifest: map[string]interface{}{
+				"mediaType": "application/vnd.oci.image.index.v1+json",
+				"manifests": []map[string]string{{"digest": "sha256:" + childDigest}},
+			},
+			extra:    map[string][]byte{childDigest: childManifest},
+			wantUsed: []string{childDigest, childCfgDigest, childLayerDigest},
+		},
+		{
+			name: "index without a mediaType is still walked via env.Manifests",
+			manifest: map[string]interface{}{
+				"manifests": []map[string]string{{"digest": "sha256:" + childDigest}},
+			},
+			extra:    map[string][]byte{childDigest: childManifest},
+			wantUsed: []string{childDigest, childCfgDigest, childLayerDigest},
+		},
+		{
+			name: "schema1 marks fsLayers",
+			manifest: map[string]interface{}{
+				"schemaVersion": 1,
+				"fsLayers": []map[string]string{
+					{"blobSum": "sha256:" + fsLayer1},
+					{"blobSum": "sha256:" + fsLayer2},
+				},
+			},
+			wantUsed: []string{fsLayer1, fsLayer2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			storage, err := newFsStorage(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := json.Marshal(tt.manifest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rootDigest := testDigest("root")
+			writeBlobFixture(t, root, rootDigest, data)
+
+			rd := &repoData{blobs: map[string]*blobEntry{rootDigest: {}}}
+			for _, digest := range tt.wantUsed {
+				rd.blobs[digest] = &blobEntry{}
+			}
+			for digest, raw := range tt.extra {
+				writeBlobFixture(t, root, digest, raw)
+				rd.blobs[digest] = &blobEntry{}
+			}
+
+			if err := walkManifests(context.Background(), storage, rd, []string{rootDigest}); err != nil {
+				t.Fatalf("walkManifests: %v", err)
+			}
+
+			for _, digest := range tt.wantUsed {
+				if !rd.blobs[digest].used {
+					t.Errorf("expected %s to be marked used", digest)
+				}
+			}
+		})
+	}
+}
+
+func TestWalkManifestMalformedDigestIsTreatedAsUnreadable(t *testing.T) {
+	root := t.TempDir()
+	storage, err := newFsStorage(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A manifest-list child with an empty digest, which blobDataKey would
+	// otherwise slice out of range.
+	rootDigest := testDigest("root")
+	manifest, err := json.Marshal(map[string]interface{}{
+		"mediaType": "application/vnd.docker.distribution.manifest.list.v2+json",
+		"manifests": []map[string]string{{"digest": ""}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeBlobFixture(t, root, rootDigest, manifest)
+
+	rd := &repoData{blobs: map[string]*blobEntry{rootDigest: {}}}
+
+	if err := walkManifests(context.Background(), storage, rd, []string{rootDigest}); err != nil {
+		t.Fatalf("walkManifests: %v", err)
+	}
+
+	if !rd.blobs[rootDigest].used {
+		t.Errorf("expected root manifest to still be marked used")
+	}
+}
+
+func TestWalkManifestVisitedGuardsCycles(t *testing.T) {
+	root := t.TempDir()
+	storage, err := newFsStorage(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	selfDigest := testDigest("self")
+	self, err := json.Marshal(map[string]interface{}{
+		"mediaType": "application/vnd.docker.distribution.manifest.list.v2+json",
+		"manifests": []map[string]string{{"digest": "sha256:" + selfDigest}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeBlobFixture(t, root, selfDigest, self)
+
+	rd := &repoData{blobs: map[string]*blobEntry{selfDigest: {}}}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- walkManifests(context.Background(), storage, rd, []string{selfDigest})
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("walkManifests: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("walkManifests did not terminate on a self-referencing manifest list")
+	}
+
+	if !rd.blobs[selfDigest].used {
+		t.Errorf("expected selfDigest to be marked used")
+	}
+}
+
+func TestWalkManifestDepthGuard(t *testing.T) {
+	root := t.TempDir()
+	storage, err := newFsStorage(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const chainLen = maxManifestDepth + 5
+	digests := make([]string, chainLen)
+	for i := range digests {
+		digests[i] = testDigest(fmt.Sprintf("chain%02d", i))
+	}
+	leafCfgDigest := testDigest("leafcfg")
+
+	rd := &repoData{blobs: map[string]*blobEntry{}}
+	for i, digest := range digests {
+		var manifest map[string]interface{}
+		if i == len(digests)-1 {
+			manifest = map[string]interface{}{
+				"mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+				"config":    map[string]string{"digest": "sha256:" + leafCfgDigest},
+			}
+		} else {
+			manifest = map[string]interface{}{
+				"mediaType": "application/vnd.docker.distribution.manifest.list.v2+json",
+				"manifests": []map[string]string{{"digest": "sha256:" + digests[i+1]}},
+			}
+		}
+
+		data, err := json.Marshal(manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeBlobFixture(t, root, digest, data)
+		rd.blobs[digest] = &blobEntry{}
+	}
+	rd.blobs[leafCfgDigest] = &blobEntry{}
+
+	if err := walkManifests(context.Background(), storage, rd, []string{digests[0]}); err != nil {
+		t.Fatalf("walkManifests: %v", err)
+	}
+
+	if rd.blobs[leafCfgDigest].used {
+		t.Errorf("expected the leaf config beyond maxManifestDepth to not be reached")
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// progressReporter prints periodic keys/sec and ETA updates for long-running
+// sharded listings, replacing the single indeterminate spinner the tool used
+// to show while it read repository metadata.
+type progressReporter struct {
+	label       string
+	totalShards int64
+	started     time.Time
+
+	keys       int64
+	doneShards int64
+
+	mu        sync.Mutex
+	lastPrint time.Time
+}
+
+// newProgressReporter starts a reporter for an operation made up of
+// totalShards units of work. Pass 0 if the number of shards isn't known
+// ahead of time; ETA is then logged as "unknown".
+func newProgressReporter(label string, totalShards int) *progressReporter {
+	now := time.Now()
+	return &progressReporter{
+		label:       label,
+		totalShards: int64(totalShards),
+		started:     now,
+		lastPrint:   now,
+	}
+}
+
+// AddKeys records that n more keys were observed.
+func (p *progressReporter) AddKeys(n int) {
+	atomic.AddInt64(&p.keys, int64(n))
+	p.maybePrint()
+}
+
+// ShardDone records that one shard of work finished.
+func (p *progressReporter) ShardDone() {
+	atomic.AddInt64(&p.doneShards, 1)
+	p.maybePrint()
+}
+
+func (p *progressReporter) maybePrint() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if time.Since(p.lastPrint) < 2*time.Second {
+		return
+	}
+	p.lastPrint = time.Now()
+
+	elapsed := time.Since(p.started).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+
+	keys := atomic.LoadInt64(&p.keys)
+	done := atomic.LoadInt64(&p.doneShards)
+
+	eta := "unknown"
+	if done > 0 && p.totalShards > done {
+		perShard := elapsed / float64(done)
+		remaining := time.Duration(perShard * float64(p.totalShards-done) * float64(time.Second))
+		eta = remaining.Round(time.Second).String()
+	}
+
+	slog.Info(p.label, "action", "progress", "keys", keys, "keys_per_sec", float64(keys)/elapsed,
+		"shards_done", done, "shards_total", p.totalShards, "eta", eta)
+}
+
+// Finish logs a final summary line.
+func (p *progressReporter) Finish() {
+	elapsed := time.Since(p.started).Round(time.Second)
+	slog.Info(p.label, "action", "progress-done", "keys", atomic.LoadInt64(&p.keys), "duration_ms", elapsed.Milliseconds())
+}
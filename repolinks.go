@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"strings"
+)
+
+// RepoLinkReport summarizes orphaned repository-side metadata found by
+// cleanRepoLinks: tag links and layer links whose target blob is gone, and
+// tag directories that never got a current/link written (e.g. because a
+// push was interrupted).
+type RepoLinkReport struct {
+	OrphanedTagLinks   []string
+	OrphanedLayerLinks []string
+	EmptyTagDirs       []string
+	Deleted            int
+	Errors             map[string]error
+}
+
+// cleanRepoLinks scans the repositories tree for link files that outlived
+// the blob they point at, and for tag directories that never got a
+// current/link written, then deletes the orphaned links unless dryRun is
+// set. This mirrors what `registry garbage-collect` does for repository
+// metadata, but driven purely from storage state. Tag directories without a
+// current/link are only reported: nothing under them is deleted, even though
+// they are only found through some other object listed beneath them.
+func cleanRepoLinks(ctx context.Context, storage RegistryStorage, rd *repoData, dryRun bool) (RepoLinkReport, error) {
+	report := RepoLinkReport{Errors: map[string]error{}}
+
+	tagDirHasCurrent := map[string]bool{}
+
+	err := storage.ListPrefix(ctx, "docker/registry/v2/repositories", func(obj ObjectInfo) bool {
+		key := obj.Key
+
+		if tagDir, ok := parseTagDirLink(key); ok {
+			if _, seen := tagDirHasCurrent[tagDir]; !seen {
+				tagDirHasCurrent[tagDir] = false
+			}
+
+			if strings.HasSuffix(key, "/current/link") {
+				tagDirHasCurrent[tagDir] = true
+
+				ld, err := storage.GetObject(ctx, key)
+				if err == nil {
+					digest := strings.TrimPrefix(string(ld), "sha256:")
+					if _, ok := rd.blobs[digest]; !ok {
+						report.OrphanedTagLinks = append(report.OrphanedTagLinks, key)
+					}
+				}
+			}
+			return true
+		}
+
+		if digest, ok := parseLayerLink(key); ok {
+			if _, ok := rd.blobs[digest]; !ok {
+				report.OrphanedLayerLinks = append(report.OrphanedLayerLinks, key)
+			}
+		}
+
+		return true
+	})
+	if err != nil {
+		return report, err
+	}
+
+	for tagDir, hasCurrent := range tagDirHasCurrent {
+		if !hasCurrent {
+			report.EmptyTagDirs = append(report.EmptyTagDirs, tagDir)
+		}
+	}
+
+	if dryRun {
+		return report, nil
+	}
+
+	toDelete := append(append([]string{}, report.OrphanedTagLinks...), report.OrphanedLayerLinks...)
+	if len(toDelete) == 0 {
+		return report, nil
+	}
+
+	errs, err := storage.DeleteObjects(ctx, toDelete)
+	if err != nil {
+		for _, key := range toDelete {
+			report.Errors[key] = err
+		}
+		return report, nil
+	}
+
+	report.Deleted = len(toDelete) - len(errs)
+	for key, err := range errs {
+		report.Errors[key] = err
+	}
+
+	return report, nil
+}
+
+// parseTagDirLink reports whether key lives under a repository's
+// _manifests/tags/<tag>/ directory, returning the tag directory's prefix
+// (".../_manifests/tags/<tag>") so sibling keys can be grouped together.
+func parseTagDirLink(key string) (string, bool) {
+	const marker = "/_manifests/tags/"
+	idx := strings.Index(key, marker)
+	if idx == -1 {
+		return "", false
+	}
+
+	rest := key[idx+len(marker):]
+	tag := rest
+	if slash := strings.Index(rest, "/"); slash != -1 {
+		tag = rest[:slash]
+	}
+	if tag == "" {
+		return "", false
+	}
+
+	return key[:idx] + marker + tag, true
+}
+
+// parseLayerLink reports whether key is a repository _layers link and, if
+// so, the digest it's named after.
+func parseLayerLink(key string) (string, bool) {
+	const marker = "/_layers/sha256/"
+	idx := strings.Index(key, marker)
+	if idx == -1 || !strings.HasSuffix(key, "/link") {
+		return "", false
+	}
+
+	digest := strings.TrimSuffix(key[idx+len(marker):], "/link")
+	if digest == "" || strings.Contains(digest, "/") {
+		return "", false
+	}
+
+	return digest, true
+}
@@ -0,0 +1,97 @@
+package main
+
+import "testing"
+
+func TestParseTagDirLink(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		wantDir string
+		wantOK  bool
+	}{
+		{
+			name:    "current link",
+			key:     "docker/registry/v2/repositories/myapp/_manifests/tags/latest/current/link",
+			wantDir: "docker/registry/v2/repositories/myapp/_manifests/tags/latest",
+			wantOK:  true,
+		},
+		{
+			name:    "index link under the same tag",
+			key:     "docker/registry/v2/repositories/myapp/_manifests/tags/latest/index/sha256/abc/link",
+			wantDir: "docker/registry/v2/repositories/myapp/_manifests/tags/latest",
+			wantOK:  true,
+		},
+		{
+			name:    "nested repository name",
+			key:     "docker/registry/v2/repositories/org/team/app/_manifests/tags/v1.0/current/link",
+			wantDir: "docker/registry/v2/repositories/org/team/app/_manifests/tags/v1.0",
+			wantOK:  true,
+		},
+		{
+			name:   "revision link is not a tag link",
+			key:    "docker/registry/v2/repositories/myapp/_manifests/revisions/sha256/abc/link",
+			wantOK: false,
+		},
+		{
+			name:   "unrelated key",
+			key:    "docker/registry/v2/blobs/sha256/ab/abc123/data",
+			wantOK: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, ok := parseTagDirLink(tt.key)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && dir != tt.wantDir {
+				t.Errorf("dir = %q, want %q", dir, tt.wantDir)
+			}
+		})
+	}
+}
+
+func TestParseLayerLink(t *testing.T) {
+	tests := []struct {
+		name       string
+		key        string
+		wantDigest string
+		wantOK     bool
+	}{
+		{
+			name:       "valid layer link",
+			key:        "docker/registry/v2/repositories/myapp/_layers/sha256/abc123/link",
+			wantDigest: "abc123",
+			wantOK:     true,
+		},
+		{
+			name:       "nested repository name",
+			key:        "docker/registry/v2/repositories/org/team/app/_layers/sha256/def456/link",
+			wantDigest: "def456",
+			wantOK:     true,
+		},
+		{
+			name:   "missing link suffix is not a layer link",
+			key:    "docker/registry/v2/repositories/myapp/_layers/sha256/abc123/data",
+			wantOK: false,
+		},
+		{
+			name:   "tag link is not a layer link",
+			key:    "docker/registry/v2/repositories/myapp/_manifests/tags/latest/current/link",
+			wantOK: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			digest, ok := parseLayerLink(tt.key)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && digest != tt.wantDigest {
+				t.Errorf("digest = %q, want %q", digest, tt.wantDigest)
+			}
+		})
+	}
+}
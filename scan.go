@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// maxRepoDiscoveryDepth bounds how deep discoverRepositories will descend
+// looking for a repository root, as a guard against pathological storage
+// layouts.
+const maxRepoDiscoveryDepth = 32
+
+// blobShardPrefixes returns the 256 two-hex-character sha256 subprefixes
+// used to fan blob listing out across workers, e.g.
+// "docker/registry/v2/blobs/sha256/00/".
+func blobShardPrefixes() []string {
+	prefixes := make([]string, 0, 256)
+	for i := 0; i < 256; i++ {
+		prefixes = append(prefixes, fmt.Sprintf("docker/registry/v2/blobs/sha256/%02x/", i))
+	}
+	return prefixes
+}
+
+// listBlobsSharded lists every blob under docker/registry/v2/blobs/sha256,
+// fanning the 256 two-hex-character subprefixes out across concurrency
+// workers instead of walking the whole tree with one serial listing.
+func listBlobsSharded(ctx context.Context, storage RegistryStorage, concurrency int, progress *progressReporter) (map[string]*blobEntry, error) {
+	blobs := map[string]*blobEntry{}
+	var mu sync.Mutex
+
+	err := forEachShard(blobShardPrefixes(), concurrency, func(prefix string) error {
+		found := 0
+		err := storage.ListPrefix(ctx, prefix, func(obj ObjectInfo) bool {
+			objectsListedTotal.Inc()
+
+			if !isBlob(obj.Key) {
+				return true
+			}
+
+			sha256, err := sha256FromBlobKey(obj.Key)
+			if err != nil {
+				return true
+			}
+
+			entry := &blobEntry{
+				key:          obj.Key,
+				size:         obj.Size,
+				lastModified: obj.LastModified,
+			}
+
+			mu.Lock()
+			blobs[sha256] = entry
+			mu.Unlock()
+			blobsSeenTotal.Inc()
+			found++
+			return true
+		})
+		if progress != nil {
+			progress.AddKeys(found)
+			progress.ShardDone()
+		}
+		return err
+	})
+
+	return blobs, err
+}
+
+// discoverRepositories walks the repositories tree via ListDir, returning
+// the root prefix of every repository found (a directory with a
+// _manifests subdirectory), so repository link scanning can be sharded per
+// repository instead of walked as one serial listing.
+func discoverRepositories(ctx context.Context, storage RegistryStorage, root string) ([]string, error) {
+	var repos []string
+
+	var walk func(prefix string, depth int) error
+	walk = func(prefix string, depth int) error {
+		if depth > maxRepoDiscoveryDepth {
+			return nil
+		}
+
+		children, err := storage.ListDir(ctx, prefix)
+		if err != nil {
+			return err
+		}
+
+		for _, child := range children {
+			if strings.HasSuffix(child, "/_manifests/") {
+				repos = append(repos, prefix)
+				return nil
+			}
+		}
+
+		for _, child := range children {
+			if err := walk(child, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := walk(root, 0); err != nil {
+		return nil, err
+	}
+
+	return repos, nil
+}
+
+// listRepoLinksSharded scans every repository's link files, fanning the work
+// out per repository across concurrency workers, marking referenced blobs
+// as used in rd and collecting manifest roots (tag heads and revisions) for
+// walkManifests to follow afterwards.
+func listRepoLinksSharded(ctx context.Context, storage RegistryStorage, rd *repoData, concurrency int, progress *progressReporter) ([]string, error) {
+	repos, err := discoverRepositories(ctx, storage, "docker/registry/v2/repositories/")
+	if err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	var manifestRoots []string
+
+	err = forEachShard(repos, concurrency, func(repoPrefix string) error {
+		found := 0
+		err := storage.ListPrefix(ctx, repoPrefix, func(obj ObjectInfo) bool {
+			objectsListedTotal.Inc()
+
+			if !isRepoLink(obj.Key) {
+				return true
+			}
+
+			ld, err := storage.GetObject(ctx, obj.Key)
+			if err != nil {
+				return true
+			}
+			sha256 := strings.TrimPrefix(string(ld), "sha256:")
+
+			mu.Lock()
+			if entry, ok := rd.blobs[sha256]; ok && !entry.used {
+				entry.used = true
+				blobsReferencedTotal.Inc()
+			}
+			if isTagCurrentLink(obj.Key) || isRevisionLink(obj.Key) {
+				manifestRoots = append(manifestRoots, sha256)
+			}
+			mu.Unlock()
+
+			found++
+			return true
+		})
+		if progress != nil {
+			progress.AddKeys(found)
+			progress.ShardDone()
+		}
+		return err
+	})
+
+	return manifestRoots, err
+}
+
+// forEachShard runs fn for every item in shards across a pool of
+// concurrency workers, returning the first error encountered. An error does
+// not stop or cancel anything: every shard is still run to completion.
+func forEachShard(shards []string, concurrency int, fn func(string) error) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	items := make(chan string)
+	errs := make(chan error, len(shards))
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range items {
+				errs <- fn(item)
+			}
+		}()
+	}
+
+	go func() {
+		for _, s := range shards {
+			items <- s
+		}
+		close(items)
+	}()
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// ObjectInfo describes a single object returned by ListPrefix or Stat.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// RegistryStorage abstracts the blob/metadata store a docker registry
+// persists to, so readRepo and cleanUnused can operate against AWS S3,
+// an S3-compatible endpoint, or a local filesystem layout without change.
+// Every implementation is rooted at the registry's storage root, i.e. keys
+// are paths like "docker/registry/v2/blobs/sha256/00/<digest>/data".
+type RegistryStorage interface {
+	// ListPrefix streams every object under prefix to fn, in no particular
+	// order. Iteration stops early if fn returns false.
+	ListPrefix(ctx context.Context, prefix string, fn func(ObjectInfo) bool) error
+
+	// ListDir returns the immediate subdirectories ("common prefixes") under
+	// prefix, each ending in "/". It's used to discover the repository tree
+	// without walking every object beneath it, so listing can be sharded per
+	// repository instead of run as one serial walk.
+	ListDir(ctx context.Context, prefix string) ([]string, error)
+
+	// GetObject returns the full contents of key.
+	GetObject(ctx context.Context, key string) ([]byte, error)
+
+	// DeleteObjects deletes keys, returning a per-key error for any that
+	// failed to delete. A nil map means every key was deleted successfully.
+	DeleteObjects(ctx context.Context, keys []string) (map[string]error, error)
+
+	// Stat returns metadata for a single key.
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+}
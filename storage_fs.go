@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// errStopWalk unwinds filepath.Walk early; it is never surfaced to callers.
+var errStopWalk = errors.New("stop walk")
+
+// fsStorage implements RegistryStorage against a local directory laid out
+// like a docker/registry/v2 filesystem storage driver root, so the cleaner
+// can be pointed at on-prem registries that don't use S3 at all.
+type fsStorage struct {
+	root string
+}
+
+func newFsStorage(root string) (*fsStorage, error) {
+	if root == "" {
+		return nil, fmt.Errorf("filesystem storage: root is required")
+	}
+
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, fmt.Errorf("filesystem storage: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("filesystem storage: %s is not a directory", root)
+	}
+
+	return &fsStorage{root: root}, nil
+}
+
+func (f *fsStorage) ListPrefix(ctx context.Context, prefix string, fn func(ObjectInfo) bool) error {
+	base := filepath.Join(f.root, prefix)
+
+	_, err := os.Stat(base)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = filepath.Walk(base, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(f.root, path)
+		if err != nil {
+			return err
+		}
+
+		if !fn(ObjectInfo{
+			Key:          filepath.ToSlash(rel),
+			Size:         info.Size(),
+			LastModified: info.ModTime(),
+		}) {
+			return errStopWalk
+		}
+		return nil
+	})
+	if err == errStopWalk {
+		return nil
+	}
+	return err
+}
+
+// ListDir returns the immediate subdirectories under prefix, each ending in
+// "/", to mirror the S3 backend's delimiter-based listing.
+func (f *fsStorage) ListDir(ctx context.Context, prefix string) ([]string, error) {
+	dirPath := filepath.Join(f.root, prefix)
+
+	entries, err := ioutil.ReadDir(dirPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var dirs []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dirs = append(dirs, filepath.ToSlash(filepath.Join(prefix, entry.Name()))+"/")
+	}
+
+	return dirs, nil
+}
+
+func (f *fsStorage) GetObject(ctx context.Context, key string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(f.root, key))
+}
+
+func (f *fsStorage) DeleteObjects(ctx context.Context, keys []string) (map[string]error, error) {
+	var errs map[string]error
+	for _, key := range keys {
+		if err := os.Remove(filepath.Join(f.root, key)); err != nil {
+			if errs == nil {
+				errs = map[string]error{}
+			}
+			errs[key] = err
+		}
+	}
+	return errs, nil
+}
+
+func (f *fsStorage) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	info, err := os.Stat(filepath.Join(f.root, key))
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	return ObjectInfo{
+		Key:          key,
+		Size:         info.Size(),
+		LastModified: info.ModTime(),
+	}, nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// recordAPIError increments storageAPIErrorsTotal, keyed by the AWS error
+// code when err is an awserr.Error, or "unknown" otherwise.
+func recordAPIError(err error) {
+	code := "unknown"
+	if awsErr, ok := err.(awserr.Error); ok {
+		code = awsErr.Code()
+	}
+	storageAPIErrorsTotal.WithLabelValues(code).Inc()
+}
+
+// S3Options configures an s3Storage. Endpoint, PathStyle and Region let the
+// same implementation talk to AWS S3 or any S3-compatible endpoint (MinIO,
+// Ceph RGW, Scaleway, Wasabi, ...).
+type S3Options struct {
+	Bucket    string
+	Region    string
+	Endpoint  string
+	PathStyle bool
+}
+
+// s3Storage implements RegistryStorage against AWS S3 or an S3-compatible
+// endpoint.
+type s3Storage struct {
+	bucket     string
+	svc        *s3.S3
+	downloader *s3manager.Downloader
+}
+
+func newS3Storage(opts S3Options) (*s3Storage, error) {
+	if opts.Bucket == "" {
+		return nil, fmt.Errorf("s3 storage: bucket is required")
+	}
+
+	region := opts.Region
+	if region == "" {
+		region = endpoints.UsEast1RegionID
+	}
+
+	cfg := &aws.Config{
+		Region: aws.String(region),
+	}
+	if opts.Endpoint != "" {
+		cfg.Endpoint = aws.String(opts.Endpoint)
+	}
+	if opts.PathStyle {
+		cfg.S3ForcePathStyle = aws.Bool(true)
+	}
+
+	sess := session.Must(session.NewSession())
+	svc := s3.New(sess, cfg)
+
+	return &s3Storage{
+		bucket:     opts.Bucket,
+		svc:        svc,
+		downloader: s3manager.NewDownloaderWithClient(svc),
+	}, nil
+}
+
+func (s *s3Storage) ListPrefix(ctx context.Context, prefix string, fn func(ObjectInfo) bool) error {
+	storageAPICallsTotal.WithLabelValues("list").Inc()
+
+	start := time.Now()
+	err := s.svc.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(s.bucket),
+		Prefix:  aws.String(prefix),
+		MaxKeys: aws.Int64(1000),
+	}, func(p *s3.ListObjectsV2Output, last bool) bool {
+		listPageLatency.Observe(time.Since(start).Seconds())
+
+		for _, obj := range p.Contents {
+			if !fn(ObjectInfo{
+				Key:          aws.StringValue(obj.Key),
+				Size:         aws.Int64Value(obj.Size),
+				LastModified: aws.TimeValue(obj.LastModified),
+			}) {
+				return false
+			}
+		}
+
+		// Another page follows: one more list call, timed from here.
+		if !last {
+			storageAPICallsTotal.WithLabelValues("list").Inc()
+		}
+		start = time.Now()
+		return true
+	})
+	if err != nil {
+		recordAPIError(err)
+	}
+	return err
+}
+
+// ListDir returns the immediate "subdirectories" under prefix using
+// Delimiter-based listing, without walking every object beneath them.
+func (s *s3Storage) ListDir(ctx context.Context, prefix string) ([]string, error) {
+	storageAPICallsTotal.WithLabelValues("list").Inc()
+
+	var dirs []string
+
+	start := time.Now()
+	err := s.svc.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(s.bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	}, func(p *s3.ListObjectsV2Output, last bool) bool {
+		listPageLatency.Observe(time.Since(start).Seconds())
+
+		for _, cp := range p.CommonPrefixes {
+			dirs = append(dirs, aws.StringValue(cp.Prefix))
+		}
+
+		// Another page follows: one more list call, timed from here.
+		if !last {
+			storageAPICallsTotal.WithLabelValues("list").Inc()
+		}
+		start = time.Now()
+		return true
+	})
+	if err != nil {
+		recordAPIError(err)
+	}
+
+	return dirs, err
+}
+
+func (s *s3Storage) GetObject(ctx context.Context, key string) ([]byte, error) {
+	storageAPICallsTotal.WithLabelValues("get").Inc()
+
+	buf := &aws.WriteAtBuffer{}
+	n, err := s.downloader.DownloadWithContext(ctx, buf, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		recordAPIError(err)
+		return nil, err
+	}
+
+	return buf.Bytes()[:n], nil
+}
+
+func (s *s3Storage) DeleteObjects(ctx context.Context, keys []string) (map[string]error, error) {
+	storageAPICallsTotal.WithLabelValues("delete").Inc()
+
+	start := time.Now()
+	objects := make([]*s3.ObjectIdentifier, len(keys))
+	for i, key := range keys {
+		objects[i] = &s3.ObjectIdentifier{Key: aws.String(key)}
+	}
+
+	out, err := s.svc.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(s.bucket),
+		Delete: &s3.Delete{Objects: objects, Quiet: aws.Bool(false)},
+	})
+	deleteBatchLatency.Observe(time.Since(start).Seconds())
+	if err != nil {
+		recordAPIError(err)
+		return nil, err
+	}
+
+	if len(out.Errors) == 0 {
+		return nil, nil
+	}
+
+	errs := map[string]error{}
+	for _, e := range out.Errors {
+		storageAPIErrorsTotal.WithLabelValues(aws.StringValue(e.Code)).Inc()
+		errs[aws.StringValue(e.Key)] = fmt.Errorf("%s: %s", aws.StringValue(e.Code), aws.StringValue(e.Message))
+	}
+
+	return errs, nil
+}
+
+func (s *s3Storage) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	storageAPICallsTotal.WithLabelValues("head").Inc()
+
+	out, err := s.svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		recordAPIError(err)
+		return ObjectInfo{}, err
+	}
+
+	return ObjectInfo{
+		Key:          key,
+		Size:         aws.Int64Value(out.ContentLength),
+		LastModified: aws.TimeValue(out.LastModified),
+	}, nil
+}